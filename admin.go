@@ -0,0 +1,148 @@
+package cloudfrontoriginpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// instances tracks every Provisioned Source so the admin endpoint can find
+// one to report on or refresh, without threading a reference through the
+// rest of Caddy's config graph.
+var (
+	instancesMu sync.RWMutex
+	instances   []*GenericSource
+)
+
+func registerInstance(s *GenericSource) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	instances = append(instances, s)
+}
+
+func unregisterInstance(s *GenericSource) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	for i, inst := range instances {
+		if inst == s {
+			instances = append(instances[:i], instances[i+1:]...)
+			return
+		}
+	}
+}
+
+func allInstances() []*GenericSource {
+	instancesMu.RLock()
+	defer instancesMu.RUnlock()
+	out := make([]*GenericSource, len(instances))
+	copy(out, instances)
+	return out
+}
+
+func init() { caddy.RegisterModule((*Admin)(nil)) }
+
+// Admin mounts the cloudfront_origin_pl status/refresh routes onto Caddy's
+// admin API. It has no configuration of its own: it just reports on
+// whichever Source instances are currently provisioned.
+//
+//	{
+//	  admin {
+//	    ...
+//	  }
+//	}
+type Admin struct{}
+
+// CaddyModule returns the Caddy module information.
+func (*Admin) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.cloudfront_origin_pl",
+		New: func() caddy.Module { return new(Admin) },
+	}
+}
+
+// Routes returns the admin routes for this module.
+func (a *Admin) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/cloudfront_origin_pl/status", Handler: caddy.AdminHandlerFunc(a.serveStatus)},
+		{Pattern: "/cloudfront_origin_pl/refresh", Handler: caddy.AdminHandlerFunc(a.serveRefresh)},
+	}
+}
+
+// instanceStatus is the JSON shape returned by /cloudfront_origin_pl/status.
+type instanceStatus struct {
+	Region              string    `json:"region,omitempty"`
+	Regions             []string  `json:"regions,omitempty"`
+	Prefixes            []string  `json:"prefixes"`
+	PrefixCount         int       `json:"prefix_count"`
+	SourceListIDs       []string  `json:"source_list_ids,omitempty"`
+	LastRefresh         time.Time `json:"last_refresh,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+func (a *Admin) serveStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: errMethodNotAllowed}
+	}
+
+	var out []instanceStatus
+	for _, s := range allInstances() {
+		prefixes := s.snapshot()
+		names := make([]string, len(prefixes))
+		for i, p := range prefixes {
+			names[i] = p.String()
+		}
+		s.mu.RLock()
+		status := instanceStatus{
+			Region:              s.Region,
+			Regions:             s.Regions,
+			Prefixes:            names,
+			PrefixCount:         len(names),
+			SourceListIDs:       s.sourceListIDs,
+			LastRefresh:         s.lastRefresh,
+			ConsecutiveFailures: s.consecutiveFailures,
+		}
+		s.mu.RUnlock()
+		out = append(out, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(out)
+}
+
+// serveRefresh forces a synchronous refresh of every provisioned instance.
+// Like the rest of the admin API, access to this endpoint is controlled by
+// Caddy's admin listener/ACL configuration, not by this module.
+func (a *Admin) serveRefresh(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: errMethodNotAllowed}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var errs []error
+	for _, s := range allInstances() {
+		if err := s.safeRefreshOnce(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: errs[0]}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module      = (*Admin)(nil)
+	_ caddy.AdminRouter = (*Admin)(nil)
+)
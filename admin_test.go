@@ -0,0 +1,116 @@
+package cloudfrontoriginpl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// withRegisteredInstance registers s with the admin endpoint's global
+// instance list for the duration of the test, then unregisters it.
+func withRegisteredInstance(t *testing.T, s *GenericSource) {
+	t.Helper()
+	registerInstance(s)
+	t.Cleanup(func() { unregisterInstance(s) })
+}
+
+func TestServeStatusMethodNotAllowed(t *testing.T) {
+	a := &Admin{}
+	req := httptest.NewRequest(http.MethodPost, "/cloudfront_origin_pl/status", nil)
+	rec := httptest.NewRecorder()
+
+	err := a.serveStatus(rec, req)
+	var apiErr caddy.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusMethodNotAllowed {
+		t.Fatalf("serveStatus(POST) error = %v, want a 405 APIError", err)
+	}
+}
+
+func TestServeStatusReportsInstances(t *testing.T) {
+	s := &GenericSource{
+		Region:  "us-east-1",
+		current: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+	}
+	withRegisteredInstance(t, s)
+
+	a := &Admin{}
+	req := httptest.NewRequest(http.MethodGet, "/cloudfront_origin_pl/status", nil)
+	rec := httptest.NewRecorder()
+
+	if err := a.serveStatus(rec, req); err != nil {
+		t.Fatalf("serveStatus: %v", err)
+	}
+
+	var out []instanceStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	found := false
+	for _, st := range out {
+		if st.Region == "us-east-1" && st.PrefixCount == 1 && st.Prefixes[0] == "192.0.2.0/24" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("status response %+v does not contain the registered instance", out)
+	}
+}
+
+func TestServeRefreshMethodNotAllowed(t *testing.T) {
+	a := &Admin{}
+	req := httptest.NewRequest(http.MethodGet, "/cloudfront_origin_pl/refresh", nil)
+	rec := httptest.NewRecorder()
+
+	err := a.serveRefresh(rec, req)
+	var apiErr caddy.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusMethodNotAllowed {
+		t.Fatalf("serveRefresh(GET) error = %v, want a 405 APIError", err)
+	}
+}
+
+func TestServeRefreshPropagatesFailure(t *testing.T) {
+	withFakePublicRangesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	s := &GenericSource{NoAuth: true, PublicRangesService: "CLOUDFRONT_ORIGIN_FACING"}
+	withRegisteredInstance(t, s)
+
+	a := &Admin{}
+	req := httptest.NewRequest(http.MethodPost, "/cloudfront_origin_pl/refresh", nil)
+	rec := httptest.NewRecorder()
+
+	err := a.serveRefresh(rec, req)
+	var apiErr caddy.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusInternalServerError {
+		t.Fatalf("serveRefresh error = %v, want a 500 APIError propagating the refresh failure", err)
+	}
+}
+
+func TestServeRefreshSuccess(t *testing.T) {
+	withFakePublicRangesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIPRangesDoc))
+	})
+
+	s := &GenericSource{NoAuth: true, PublicRangesService: "CLOUDFRONT_ORIGIN_FACING"}
+	withRegisteredInstance(t, s)
+
+	a := &Admin{}
+	req := httptest.NewRequest(http.MethodPost, "/cloudfront_origin_pl/refresh", nil)
+	rec := httptest.NewRecorder()
+
+	if err := a.serveRefresh(rec, req); err != nil {
+		t.Fatalf("serveRefresh: %v", err)
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := s.snapshot(); len(got) != 1 {
+		t.Errorf("snapshot after refresh = %v, want 1 prefix", got)
+	}
+}
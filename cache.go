@@ -0,0 +1,122 @@
+package cloudfrontoriginpl
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// cacheSnapshot is the on-disk format written to CacheFile after every
+// successful refresh and read back by loadCache on Provision.
+type cacheSnapshot struct {
+	Prefixes      []string  `json:"prefixes"`
+	FetchedAt     time.Time `json:"fetched_at"`
+	Region        string    `json:"region"`
+	SourceListIDs []string  `json:"source_list_ids,omitempty"`
+}
+
+// loadCache installs a previously-persisted snapshot as the initial prefix
+// set, if cache_file is configured, the file exists, and it isn't older
+// than max_cache_age (when set). A stale or unreadable cache is logged and
+// otherwise ignored; refreshOnce still runs normally afterwards.
+func (s *GenericSource) loadCache() {
+	if s.CacheFile == "" {
+		return
+	}
+	data, err := os.ReadFile(s.CacheFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			caddy.Log().Warn("cloudfront_origin_pl: could not read cache_file", zap.Error(err))
+		}
+		return
+	}
+	var snap cacheSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		caddy.Log().Warn("cloudfront_origin_pl: could not parse cache_file", zap.Error(err))
+		return
+	}
+
+	if maxAge := time.Duration(s.MaxCacheAge); maxAge > 0 {
+		if age := time.Since(snap.FetchedAt); age > maxAge {
+			caddy.Log().Warn("cloudfront_origin_pl: cached snapshot is stale; not serving it",
+				zap.Duration("age", age), zap.Duration("max_cache_age", maxAge))
+			return
+		}
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(snap.Prefixes))
+	for _, p := range snap.Prefixes {
+		pfx, err := netip.ParsePrefix(p)
+		if err != nil {
+			continue // skip malformed
+		}
+		prefixes = append(prefixes, pfx)
+	}
+	if len(prefixes) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	s.current = prefixes
+	s.sourceListIDs = snap.SourceListIDs
+	s.mu.Unlock()
+	s.metrics.setPrefixCounts(countByFamily(prefixes))
+
+	caddy.Log().Info("cloudfront_origin_pl: loaded cached prefix set",
+		zap.Int("count", len(prefixes)), zap.Time("fetched_at", snap.FetchedAt))
+}
+
+// writeCache atomically persists the current prefix set to CacheFile (tmp
+// file + rename), so the next Provision can warm-start from it. Failures
+// are logged, not returned, since a cache write should never fail a
+// refresh that otherwise succeeded.
+func (s *GenericSource) writeCache() {
+	if s.CacheFile == "" {
+		return
+	}
+
+	s.mu.RLock()
+	snap := cacheSnapshot{
+		Prefixes:      make([]string, len(s.current)),
+		FetchedAt:     time.Now(),
+		Region:        s.Region,
+		SourceListIDs: s.sourceListIDs,
+	}
+	for i, p := range s.current {
+		snap.Prefixes[i] = p.String()
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		caddy.Log().Warn("cloudfront_origin_pl: could not marshal cache snapshot", zap.Error(err))
+		return
+	}
+
+	dir := filepath.Dir(s.CacheFile)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.CacheFile)+".tmp-*")
+	if err != nil {
+		caddy.Log().Warn("cloudfront_origin_pl: could not create cache_file temp file", zap.Error(err))
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		caddy.Log().Warn("cloudfront_origin_pl: could not write cache_file", zap.Error(err))
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		caddy.Log().Warn("cloudfront_origin_pl: could not close cache_file temp file", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.CacheFile); err != nil {
+		caddy.Log().Warn("cloudfront_origin_pl: could not rename cache_file into place", zap.Error(err))
+		return
+	}
+}
@@ -0,0 +1,51 @@
+package cloudfrontoriginpl
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestCacheWriteLoadRoundTrip(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "prefixes.json")
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	writer := &GenericSource{CacheFile: cacheFile, current: prefixes, sourceListIDs: []string{"pl-abc123"}}
+	writer.writeCache()
+
+	reader := &GenericSource{CacheFile: cacheFile}
+	reader.loadCache()
+
+	got := reader.snapshot()
+	if len(got) != len(prefixes) {
+		t.Fatalf("loaded %d prefixes, want %d", len(got), len(prefixes))
+	}
+	for i, p := range prefixes {
+		if got[i] != p {
+			t.Errorf("prefix %d = %v, want %v", i, got[i], p)
+		}
+	}
+	if len(reader.sourceListIDs) != 1 || reader.sourceListIDs[0] != "pl-abc123" {
+		t.Errorf("sourceListIDs = %v, want [pl-abc123]", reader.sourceListIDs)
+	}
+}
+
+func TestCacheLoadStaleIsIgnored(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "prefixes.json")
+	writer := &GenericSource{CacheFile: cacheFile, current: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	writer.writeCache()
+
+	// A 1ns max age guarantees the snapshot looks stale by the time it's read.
+	reader := &GenericSource{CacheFile: cacheFile, MaxCacheAge: caddy.Duration(time.Nanosecond)}
+	reader.loadCache()
+
+	if got := reader.snapshot(); len(got) != 0 {
+		t.Fatalf("snapshot = %v, want empty (stale cache should not be loaded)", got)
+	}
+}
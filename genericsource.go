@@ -0,0 +1,883 @@
+package cloudfrontoriginpl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// Module ID: usable as `source aws_managed_prefix_list { ... }`
+func init() { caddy.RegisterModule((*GenericSource)(nil)) }
+
+// GenericSource implements caddyhttp.IPRangeSource against one or more
+// arbitrary AWS managed prefix lists. CloudFrontSource is a thin preset of
+// this module for the two CloudFront origin-facing lists; use GenericSource
+// directly to trust API Gateway, S3, your own customer-managed lists, etc.
+//
+// Caddyfile:
+//
+//	trusted_proxies {
+//	  source aws_managed_prefix_list {
+//	    region us-east-1
+//	    // or: regions us-east-1 eu-west-1 ap-southeast-2
+//	    refresh 12h
+//	    prefix_list_id pl-abcdef01           # repeatable
+//	    prefix_list_name com.example.my-list  # repeatable
+//	    aws_profile myprofile
+//	    role_arn arn:aws:iam::123456789012:role/MyRole          # repeatable, chains
+//	    web_identity_token_file /var/run/secrets/eks.amazonaws.com/serviceaccount/token  # IRSA
+//	    external_id my-external-id
+//	    session_name cf-origin-pl
+//	    imds_disabled
+//	    use_public_ranges true   # requires public_ranges_service
+//	    public_ranges_service S3
+//	    cache_file /var/lib/caddy/aws_managed_prefix_list.json
+//	    max_cache_age 48h
+//	  }
+//	}
+type GenericSource struct {
+	Region string `json:"region,omitempty"`
+	// Regions, if set, fans refreshFromAWS out to multiple regional
+	// managed-prefix-list APIs in parallel and unions the results. It takes
+	// precedence over Region, which remains for single-region configs.
+	Regions []string `json:"regions,omitempty"`
+
+	// PrefixListIDs and PrefixListNames are both repeatable in the
+	// Caddyfile and may be combined; names are resolved to IDs per region
+	// via DescribeManagedPrefixLists.
+	PrefixListIDs   []string `json:"prefix_list_ids,omitempty"`
+	PrefixListNames []string `json:"prefix_list_names,omitempty"`
+	IncludeIPv6     bool     `json:"include_ipv6,omitempty"`
+
+	Refresh caddy.Duration `json:"refresh,omitempty"`
+
+	// Optional auth tweaks
+	AWSProfile string `json:"aws_profile,omitempty"`
+
+	// RoleARNs is repeatable in the Caddyfile: each role_arn assumes from
+	// the credentials produced by the previous entry, so `role_arn A` then
+	// `role_arn B` assumes A first and then B from A's credentials (role
+	// chaining). If WebIdentityTokenFile is set, the first entry is assumed
+	// via AssumeRoleWithWebIdentity (IRSA) instead of a plain AssumeRole,
+	// and any further entries chain a normal AssumeRole from there.
+	RoleARNs []string `json:"role_arns,omitempty"`
+	// WebIdentityTokenFile enables IRSA-style credentials: the first
+	// RoleARNs entry is assumed via stscreds.NewWebIdentityRoleProvider
+	// using the JWT at this path, as injected by EKS/ECS into the pod.
+	WebIdentityTokenFile string `json:"web_identity_token_file,omitempty"`
+	// SessionName, if set, is applied to every assume-role call in the
+	// RoleARNs chain (including the web-identity one). ExternalID, if set,
+	// is applied to the plain AssumeRole calls only; the web-identity call
+	// is made without it.
+	ExternalID  string `json:"external_id,omitempty"`
+	SessionName string `json:"session_name,omitempty"`
+	// ImdsDisabled disables the EC2 instance metadata service credential
+	// source, for hardened hosts where IMDS access is blocked or unwanted.
+	ImdsDisabled bool `json:"imds_disabled,omitempty"`
+
+	// If true, startup/refresh will fail when the final set is empty.
+	RequireNonEmpty bool `json:"require_nonempty,omitempty"`
+
+	// UsePublicRanges, if true, skips the EC2 API entirely and resolves
+	// prefixes from the public ip-ranges.json document instead, filtered to
+	// PublicRangesService. NoAuth is an alias for the same behavior, worded
+	// for operators with no AWS credentials at all. Either way, refreshOnce
+	// also falls back to ip-ranges.json automatically when the EC2 API call
+	// fails. PublicRangesService is required whenever either is set, since
+	// (unlike CloudFrontSource) a generic prefix list has no implicit
+	// ip-ranges.json service tag.
+	UsePublicRanges     bool   `json:"use_public_ranges,omitempty"`
+	NoAuth              bool   `json:"no_auth,omitempty"`
+	PublicRangesService string `json:"public_ranges_service,omitempty"`
+
+	// CacheFile, if set, persists the resolved prefix set to disk so that
+	// Provision can serve a valid trusted-proxy set on startup even when
+	// AWS (or the public ip-ranges.json endpoint) is unreachable. MaxCacheAge
+	// bounds how old a cached snapshot may be before it's treated as stale
+	// (logged and not served) rather than loaded.
+	CacheFile   string         `json:"cache_file,omitempty"`
+	MaxCacheAge caddy.Duration `json:"max_cache_age,omitempty"`
+
+	// internal
+	mu                  sync.RWMutex
+	current             []netip.Prefix
+	stopCh              chan struct{}
+	refreshTimer        *time.Timer
+	publicRangesETag    string
+	sourceListIDs       []string
+	lastRefresh         time.Time
+	consecutiveFailures int
+	metrics             *sourceMetrics
+}
+
+// Caddy module registration
+func (*GenericSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.aws_managed_prefix_list",
+		New: func() caddy.Module { return new(GenericSource) },
+	}
+}
+
+const (
+	// Defaults
+	defaultRegion     = "us-east-1"
+	defaultRefresh    = 12 * time.Hour
+	maxResultsPerPage = 100
+
+	// Backoff applied to the background refresher after consecutive
+	// failures: minBackoffDelay, doubled per additional failure, capped at
+	// the configured Refresh interval, then full-jittered.
+	minBackoffDelay   = 30 * time.Second
+	backoffMultiplier = 2
+)
+
+// publicRangesURL is a var, not a const, so tests can point it at a fake
+// server instead of the real endpoint.
+var publicRangesURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+
+// Provision initializes config, loads initial ranges, starts refresher.
+func (s *GenericSource) Provision(ctx caddy.Context) error {
+	s.metrics = registerMetrics(ctx)
+	registerInstance(s)
+
+	if len(s.Regions) == 0 && s.Region == "" {
+		if env := os.Getenv("AWS_REGION"); env != "" {
+			s.Region = env
+		} else if env := os.Getenv("AWS_DEFAULT_REGION"); env != "" {
+			s.Region = env
+		} else {
+			s.Region = defaultRegion
+		}
+	}
+	if time.Duration(s.Refresh) == 0 {
+		s.Refresh = caddy.Duration(defaultRefresh)
+	}
+	if len(s.PrefixListIDs) == 0 && len(s.PrefixListNames) == 0 {
+		return fmt.Errorf("aws_managed_prefix_list: at least one prefix_list_id or prefix_list_name is required")
+	}
+	if (s.UsePublicRanges || s.NoAuth) && s.PublicRangesService == "" {
+		return fmt.Errorf("aws_managed_prefix_list: public_ranges_service is required when use_public_ranges/no_auth is set")
+	}
+
+	// Load a previously-persisted snapshot first, so Caddy can start serving
+	// with a valid trusted-proxy set even if AWS is unreachable right now.
+	s.loadCache()
+
+	// initial fetch (fail hard if empty, unless a cached snapshot already
+	// gave us something to serve)
+	if err := s.safeRefreshOnce(context.Background()); err != nil {
+		s.mu.Lock()
+		s.consecutiveFailures = 1
+		s.mu.Unlock()
+		if len(s.snapshot()) == 0 {
+			return err
+		}
+		caddy.Log().Warn("aws_managed_prefix_list: initial refresh failed; serving cached snapshot",
+			zap.Error(err))
+	}
+	if len(s.snapshot()) == 0 {
+		if s.RequireNonEmpty {
+			return fmt.Errorf("aws_managed_prefix_list: no prefixes found on initial fetch")
+		}
+		caddy.Log().Warn("aws_managed_prefix_list: initial fetch returned zero prefixes; running with empty set")
+	}
+
+	// background refresher: schedules itself via time.AfterFunc instead of a
+	// fixed ticker, so consecutive failures back off (with jitter) instead
+	// of hammering AWS every Refresh interval during an outage.
+	s.stopCh = make(chan struct{})
+	s.scheduleRefresh()
+	return nil
+}
+
+// Cleanup stops the background refresher.
+func (s *GenericSource) Cleanup() error {
+	if s.stopCh != nil {
+		close(s.stopCh)
+	}
+	s.mu.Lock()
+	if s.refreshTimer != nil {
+		s.refreshTimer.Stop()
+	}
+	s.mu.Unlock()
+	unregisterInstance(s)
+	return nil
+}
+
+// scheduleRefresh arms the next background refresh after nextDelay.
+func (s *GenericSource) scheduleRefresh() {
+	delay := s.nextDelay()
+	s.mu.Lock()
+	s.refreshTimer = time.AfterFunc(delay, s.runRefresh)
+	s.mu.Unlock()
+}
+
+// runRefresh performs one background refresh, updates consecutiveFailures,
+// and reschedules itself. It never returns early on error: the backoff is
+// entirely encoded in the delay scheduleRefresh computes next.
+func (s *GenericSource) runRefresh() {
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+
+	err := s.safeRefreshOnce(context.Background())
+	s.mu.Lock()
+	if err != nil {
+		s.consecutiveFailures++
+	} else {
+		s.consecutiveFailures = 0
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.stopCh:
+		return
+	default:
+		s.scheduleRefresh()
+	}
+}
+
+// safeRefreshOnce wraps refreshOnce with panic recovery, so a panicking
+// refresh (e.g. a misbehaving AWS SDK response) is logged and counted as a
+// failed attempt instead of silently killing the caller (the background
+// refresher, or an admin-triggered forced refresh).
+func (s *GenericSource) safeRefreshOnce(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("aws_managed_prefix_list: panic during refresh: %v", r)
+			caddy.Log().Error("aws_managed_prefix_list: recovered from panic in refresh goroutine",
+				zap.Any("panic", r))
+		}
+	}()
+	return s.refreshOnce(ctx)
+}
+
+// nextDelay computes the delay before the next background refresh:
+// the configured Refresh interval on success, or an exponential backoff
+// (minBackoffDelay, doubling, capped at Refresh) with full jitter after
+// consecutive failures.
+func (s *GenericSource) nextDelay() time.Duration {
+	base := time.Duration(s.Refresh)
+	s.mu.RLock()
+	failures := s.consecutiveFailures
+	s.mu.RUnlock()
+	if failures <= 0 {
+		return base
+	}
+
+	backoff := minBackoffDelay
+	for i := 1; i < failures; i++ {
+		if backoff >= base {
+			break
+		}
+		backoff *= backoffMultiplier
+	}
+	if backoff > base {
+		backoff = base
+	}
+	return fullJitter(backoff)
+}
+
+// fullJitter returns a random duration in [1, d], per the "full jitter"
+// backoff strategy (https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/).
+func fullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d))) + 1
+}
+
+// GetIPRanges is called by Caddy in hot path; return a copy.
+func (s *GenericSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]netip.Prefix, len(s.current))
+	copy(out, s.current)
+	return out
+}
+
+// snapshot returns a copy of the current prefix list under a read lock.
+func (s *GenericSource) snapshot() []netip.Prefix {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]netip.Prefix, len(s.current))
+	copy(out, s.current)
+	return out
+}
+
+// UnmarshalCaddyfile enables Caddyfile usage.
+func (s *GenericSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "region":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.Region = d.Val()
+			case "regions":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				s.Regions = args
+			case "refresh":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid refresh: %v", err)
+				}
+				s.Refresh = caddy.Duration(dur)
+			case "prefix_list_id":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.PrefixListIDs = append(s.PrefixListIDs, d.Val())
+			case "prefix_list_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.PrefixListNames = append(s.PrefixListNames, d.Val())
+			case "include_ipv6":
+				// boolean (no arg => true, or explicit true/false)
+				if d.NextArg() {
+					val := strings.ToLower(d.Val())
+					s.IncludeIPv6 = val == "true" || val == "1" || val == "yes"
+				} else {
+					s.IncludeIPv6 = true
+				}
+			case "aws_profile":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AWSProfile = d.Val()
+			case "role_arn":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.RoleARNs = append(s.RoleARNs, d.Val())
+			case "web_identity_token_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.WebIdentityTokenFile = d.Val()
+			case "external_id":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.ExternalID = d.Val()
+			case "session_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.SessionName = d.Val()
+			case "imds_disabled":
+				if d.NextArg() {
+					val := strings.ToLower(d.Val())
+					s.ImdsDisabled = val == "true" || val == "1" || val == "yes"
+				} else {
+					s.ImdsDisabled = true
+				}
+			case "require_nonempty":
+				// boolean (no arg => true, or explicit true/false)
+				if d.NextArg() {
+					val := strings.ToLower(d.Val())
+					s.RequireNonEmpty = val == "true" || val == "1" || val == "yes"
+				} else {
+					s.RequireNonEmpty = true
+				}
+			case "use_public_ranges":
+				if d.NextArg() {
+					val := strings.ToLower(d.Val())
+					s.UsePublicRanges = val == "true" || val == "1" || val == "yes"
+				} else {
+					s.UsePublicRanges = true
+				}
+			case "no_auth":
+				if d.NextArg() {
+					val := strings.ToLower(d.Val())
+					s.NoAuth = val == "true" || val == "1" || val == "yes"
+				} else {
+					s.NoAuth = true
+				}
+			case "public_ranges_service":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.PublicRangesService = d.Val()
+			case "cache_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.CacheFile = d.Val()
+			case "max_cache_age":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_cache_age: %v", err)
+				}
+				s.MaxCacheAge = caddy.Duration(dur)
+			default:
+				return d.Errf("unknown option %q", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts both the current plural fields and the original
+// (chunk0-1..chunk0-4) singular prefix_list_id/prefix_list_name/role_arn
+// fields, so hand-authored or persisted JSON configs predating the
+// multi-value/multi-role support keep unmarshaling correctly. A singular
+// value present in the JSON is appended after whatever the plural field
+// already decoded to.
+func (s *GenericSource) UnmarshalJSON(data []byte) error {
+	type alias GenericSource
+	aux := struct {
+		PrefixListID   string `json:"prefix_list_id,omitempty"`
+		PrefixListName string `json:"prefix_list_name,omitempty"`
+		RoleARN        string `json:"role_arn,omitempty"`
+		*alias
+	}{alias: (*alias)(s)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.PrefixListID != "" {
+		s.PrefixListIDs = append(s.PrefixListIDs, aux.PrefixListID)
+	}
+	if aux.PrefixListName != "" {
+		s.PrefixListNames = append(s.PrefixListNames, aux.PrefixListName)
+	}
+	if aux.RoleARN != "" {
+		s.RoleARNs = append(s.RoleARNs, aux.RoleARN)
+	}
+	return nil
+}
+
+// refreshOnce resolves the current prefix set and installs it. It normally
+// talks to the EC2 API, but falls back to the public ip-ranges.json document
+// (no AWS credentials required) when use_public_ranges/no_auth is set, or
+// when the EC2 API call itself fails.
+func (s *GenericSource) refreshOnce(ctx context.Context) error {
+	start := time.Now()
+	var err error
+	if s.UsePublicRanges || s.NoAuth {
+		err = s.refreshFromPublicRanges(ctx)
+	} else {
+		err = s.refreshFromAWS(ctx)
+		if err != nil {
+			caddy.Log().Warn("aws_managed_prefix_list: EC2 API refresh failed; falling back to public ip-ranges.json",
+				zap.Error(err), zap.String("region", s.Region))
+			err = s.refreshFromPublicRanges(ctx)
+		}
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	} else if len(s.snapshot()) == 0 {
+		result = "empty"
+	}
+	s.metrics.observeRefresh(result, time.Since(start))
+
+	if err == nil {
+		s.mu.Lock()
+		s.lastRefresh = time.Now()
+		s.mu.Unlock()
+		s.writeCache()
+	}
+	return err
+}
+
+// regionResult is the outcome of fetchRegion for one region.
+type regionResult struct {
+	region   string
+	prefixes []netip.Prefix
+	ids      []string
+	err      error
+}
+
+// effectiveRegions returns the configured region list: Regions if set,
+// otherwise the single legacy Region.
+func (s *GenericSource) effectiveRegions() []string {
+	if len(s.Regions) > 0 {
+		return s.Regions
+	}
+	return []string{s.Region}
+}
+
+// refreshFromAWS fans out to every configured region in parallel and unions
+// the results, deduping CIDRs seen in more than one region. A failure in one
+// region is logged and skipped rather than failing the whole refresh, but if
+// every region failed this returns an error of its own (distinct from
+// installPrefixes's empty-result handling) so refreshOnce still falls back
+// to public ip-ranges.json; installPrefixes is only reached, and only its
+// require_nonempty decision applies, when at least one region answered.
+func (s *GenericSource) refreshFromAWS(ctx context.Context) error {
+	regions := s.effectiveRegions()
+
+	results := make(chan regionResult, len(regions))
+	var wg sync.WaitGroup
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			prefixes, ids, err := s.fetchRegion(ctx, region)
+			results <- regionResult{region: region, prefixes: prefixes, ids: ids, err: err}
+		}(region)
+	}
+	wg.Wait()
+	close(results)
+
+	seen := map[string]struct{}{}
+	var all []netip.Prefix
+	var allIDs []string
+	succeeded := 0
+	for r := range results {
+		if r.err != nil {
+			caddy.Log().Warn("aws_managed_prefix_list: region refresh failed; skipping",
+				zap.String("region", r.region), zap.Error(r.err))
+			continue
+		}
+		succeeded++
+		for _, pfx := range r.prefixes {
+			key := pfx.String()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			all = append(all, pfx)
+		}
+		allIDs = append(allIDs, r.ids...)
+	}
+	if succeeded == 0 && len(regions) > 0 {
+		caddy.Log().Warn("aws_managed_prefix_list: all regions failed", zap.Int("region_count", len(regions)))
+		return fmt.Errorf("aws_managed_prefix_list: all %d region(s) failed to refresh", len(regions))
+	}
+
+	s.mu.Lock()
+	s.sourceListIDs = allIDs
+	s.mu.Unlock()
+
+	return s.installPrefixes(all)
+}
+
+// fetchRegion resolves and paginates the configured prefix list(s) for a
+// single region.
+func (s *GenericSource) fetchRegion(ctx context.Context, region string) ([]netip.Prefix, []string, error) {
+	cfg, err := s.loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, nil, fmt.Errorf("aws_managed_prefix_list: aws config: %w", err)
+	}
+	ec2c := ec2.NewFromConfig(cfg)
+
+	ids := append([]string{}, s.PrefixListIDs...)
+	for _, name := range s.PrefixListNames {
+		id, err := s.resolvePrefixListIDByName(ctx, ec2c, region, name)
+		if err != nil {
+			caddy.Log().Warn("aws_managed_prefix_list: could not resolve prefix list; skipping",
+				zap.String("name", name), zap.String("region", region), zap.Error(err))
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	// Fetch CIDRs from all requested lists
+	seen := map[string]struct{}{}
+	var all []netip.Prefix
+	for _, id := range ids {
+		pageToken := aws.String("")
+		for {
+			out, err := ec2c.GetManagedPrefixListEntries(ctx, &ec2.GetManagedPrefixListEntriesInput{
+				PrefixListId: aws.String(id),
+				MaxResults:   aws.Int32(maxResultsPerPage),
+				NextToken:    pageTokenOrNil(pageToken),
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("aws_managed_prefix_list: get entries %s (region %s): %w", id, region, err)
+			}
+			for _, e := range out.Entries {
+				if e.Cidr == nil {
+					continue
+				}
+				c := *e.Cidr
+				if _, ok := seen[c]; ok {
+					continue
+				}
+				pfx, perr := netip.ParsePrefix(c)
+				if perr != nil {
+					continue
+				} // skip malformed
+				all = append(all, pfx)
+				seen[c] = struct{}{}
+			}
+			if out.NextToken == nil || *out.NextToken == "" {
+				break
+			}
+			pageToken = out.NextToken
+		}
+	}
+
+	return all, ids, nil
+}
+
+// refreshFromPublicRanges resolves prefixes from the public ip-ranges.json
+// document, which requires no AWS credentials. It honors ETag/If-None-Match
+// so that frequent refreshes are cheap: a 304 leaves the current set as-is.
+func (s *GenericSource) refreshFromPublicRanges(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, publicRangesURL, nil)
+	if err != nil {
+		return fmt.Errorf("aws_managed_prefix_list: build ip-ranges.json request: %w", err)
+	}
+	s.mu.RLock()
+	etag := s.publicRangesETag
+	s.mu.RUnlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws_managed_prefix_list: fetch ip-ranges.json: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		caddy.Log().Debug("aws_managed_prefix_list: ip-ranges.json unchanged (304)")
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("aws_managed_prefix_list: fetch ip-ranges.json: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("aws_managed_prefix_list: read ip-ranges.json: %w", err)
+	}
+	var doc publicIPRangesDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("aws_managed_prefix_list: parse ip-ranges.json: %w", err)
+	}
+
+	seen := map[string]struct{}{}
+	var all []netip.Prefix
+	for _, p := range doc.Prefixes {
+		if p.Service != s.PublicRangesService || p.IPPrefix == "" {
+			continue
+		}
+		if _, ok := seen[p.IPPrefix]; ok {
+			continue
+		}
+		pfx, perr := netip.ParsePrefix(p.IPPrefix)
+		if perr != nil {
+			continue // skip malformed
+		}
+		all = append(all, pfx)
+		seen[p.IPPrefix] = struct{}{}
+	}
+	if s.IncludeIPv6 {
+		for _, p := range doc.IPv6Prefixes {
+			if p.Service != s.PublicRangesService || p.IPv6Prefix == "" {
+				continue
+			}
+			if _, ok := seen[p.IPv6Prefix]; ok {
+				continue
+			}
+			pfx, perr := netip.ParsePrefix(p.IPv6Prefix)
+			if perr != nil {
+				continue
+			}
+			all = append(all, pfx)
+			seen[p.IPv6Prefix] = struct{}{}
+		}
+	}
+
+	caddy.Log().Info("aws_managed_prefix_list: resolved prefixes from public ip-ranges.json",
+		zap.Int("count", len(all)), zap.String("sync_token", doc.SyncToken))
+
+	s.mu.Lock()
+	s.publicRangesETag = resp.Header.Get("ETag")
+	s.sourceListIDs = []string{"public:ip-ranges.json#" + doc.SyncToken}
+	s.mu.Unlock()
+
+	return s.installPrefixes(all)
+}
+
+// installPrefixes applies a freshly-resolved prefix set, honoring
+// require_nonempty and preserving the previous set on an empty result.
+func (s *GenericSource) installPrefixes(all []netip.Prefix) error {
+	if len(all) == 0 {
+		prev := s.snapshot()
+		if len(prev) == 0 && s.RequireNonEmpty {
+			return fmt.Errorf("aws_managed_prefix_list: resolved zero prefixes (empty on first load and require_nonempty=true)")
+		}
+		if len(prev) == 0 {
+			caddy.Log().Warn("aws_managed_prefix_list: resolved zero prefixes; leaving empty set (will retry)",
+				zap.String("region", s.Region))
+			s.mu.Lock()
+			s.current = nil
+			s.mu.Unlock()
+			s.metrics.setPrefixCounts(0, 0)
+			return nil
+		}
+		caddy.Log().Warn("aws_managed_prefix_list: refresh yielded zero prefixes; keeping previous set",
+			zap.Int("previous_count", len(prev)), zap.String("region", s.Region))
+		return nil
+	}
+
+	s.mu.Lock()
+	s.current = all
+	s.mu.Unlock()
+	s.metrics.setPrefixCounts(countByFamily(all))
+	return nil
+}
+
+// countByFamily splits a prefix set into IPv4/IPv6 counts for metrics.
+func countByFamily(prefixes []netip.Prefix) (v4, v6 int) {
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	return v4, v6
+}
+
+// publicIPRangesDoc mirrors the relevant subset of the published
+// ip-ranges.json schema (https://ip-ranges.amazonaws.com/ip-ranges.json).
+type publicIPRangesDoc struct {
+	SyncToken    string             `json:"syncToken"`
+	CreateDate   string             `json:"createDate"`
+	Prefixes     []publicIPv4Prefix `json:"prefixes"`
+	IPv6Prefixes []publicIPv6Prefix `json:"ipv6_prefixes"`
+}
+
+type publicIPv4Prefix struct {
+	IPPrefix           string `json:"ip_prefix"`
+	Region             string `json:"region"`
+	Service            string `json:"service"`
+	NetworkBorderGroup string `json:"network_border_group"`
+}
+
+type publicIPv6Prefix struct {
+	IPv6Prefix         string `json:"ipv6_prefix"`
+	Region             string `json:"region"`
+	Service            string `json:"service"`
+	NetworkBorderGroup string `json:"network_border_group"`
+}
+
+// loadAWSConfig builds the AWS config used for a single region, applying
+// (in order): the shared profile, IMDS enable/disable, and then the
+// RoleARNs chain. When WebIdentityTokenFile is set the first role in the
+// chain is assumed via AssumeRoleWithWebIdentity (IRSA); every subsequent
+// role (and the first, when there's no web-identity token) is a plain
+// AssumeRole from the credentials produced so far.
+func (s *GenericSource) loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(region),
+	}
+	if s.AWSProfile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(s.AWSProfile))
+	}
+	if s.ImdsDisabled {
+		opts = append(opts, awsconfig.WithEC2IMDSClientEnableState(imds.ClientDisabled))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, err
+	}
+
+	roleARNs := s.RoleARNs
+	if len(roleARNs) == 0 {
+		return cfg, nil
+	}
+
+	if s.WebIdentityTokenFile != "" {
+		stsClient := sts.NewFromConfig(cfg)
+		creds := stscreds.NewWebIdentityRoleProvider(stsClient, roleARNs[0],
+			stscreds.IdentityTokenFile(s.WebIdentityTokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				if s.SessionName != "" {
+					o.RoleSessionName = s.SessionName
+				}
+			})
+		cfg.Credentials = aws.NewCredentialsCache(creds)
+		roleARNs = roleARNs[1:]
+	}
+
+	for _, roleARN := range roleARNs {
+		stsClient := sts.NewFromConfig(cfg)
+		creds := stscreds.NewAssumeRoleProvider(stsClient, roleARN, func(o *stscreds.AssumeRoleOptions) {
+			if s.ExternalID != "" {
+				o.ExternalID = aws.String(s.ExternalID)
+			}
+			if s.SessionName != "" {
+				o.RoleSessionName = s.SessionName
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(creds)
+	}
+	return cfg, nil
+}
+
+func (s *GenericSource) resolvePrefixListIDByName(ctx context.Context, ec2c *ec2.Client, region, name string) (string, error) {
+	out, err := ec2c.DescribeManagedPrefixLists(ctx, &ec2.DescribeManagedPrefixListsInput{
+		Filters: []ec2types.Filter{{
+			Name:   aws.String("prefix-list-name"),
+			Values: []string{name},
+		}},
+		MaxResults: aws.Int32(100),
+	})
+	if err != nil {
+		return "", fmt.Errorf("describe prefix lists: %w", err)
+	}
+	for _, pl := range out.PrefixLists {
+		if pl.PrefixListName != nil && *pl.PrefixListName == name && pl.PrefixListId != nil {
+			return *pl.PrefixListId, nil
+		}
+	}
+	return "", fmt.Errorf("managed prefix list %q not found in region %s", name, region)
+}
+
+func pageTokenOrNil(t *string) *string {
+	if t == nil || *t == "" {
+		return nil
+	}
+	return t
+}
+
+// Interface guards
+var _ caddy.Provisioner = (*GenericSource)(nil)
+var _ caddy.CleanerUpper = (*GenericSource)(nil)
+var _ caddyfile.Unmarshaler = (*GenericSource)(nil)
+var _ caddyhttp.IPRangeSource = (*GenericSource)(nil)
+var _ caddy.Module = (*GenericSource)(nil)
+var _ json.Unmarshaler = (*GenericSource)(nil)
@@ -0,0 +1,45 @@
+package cloudfrontoriginpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestNextDelay(t *testing.T) {
+	s := &GenericSource{Refresh: caddy.Duration(10 * time.Minute)}
+
+	if got := s.nextDelay(); got != 10*time.Minute {
+		t.Errorf("no failures: got %v, want %v", got, 10*time.Minute)
+	}
+
+	s.consecutiveFailures = 1
+	if got := s.nextDelay(); got <= 0 || got > minBackoffDelay {
+		t.Errorf("1 failure: got %v, want in (0, %v]", got, minBackoffDelay)
+	}
+
+	s.consecutiveFailures = 2
+	if got := s.nextDelay(); got <= 0 || got > 2*minBackoffDelay {
+		t.Errorf("2 failures: got %v, want in (0, %v]", got, 2*minBackoffDelay)
+	}
+
+	// Enough failures that the doubling would overshoot Refresh: the delay
+	// must still be capped at Refresh (before jitter).
+	s.consecutiveFailures = 20
+	if got := s.nextDelay(); got <= 0 || got > 10*time.Minute {
+		t.Errorf("many failures: got %v, want in (0, %v]", got, 10*time.Minute)
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	if got := fullJitter(0); got != 0 {
+		t.Errorf("fullJitter(0) = %v, want 0", got)
+	}
+	for i := 0; i < 100; i++ {
+		got := fullJitter(time.Second)
+		if got <= 0 || got > time.Second {
+			t.Fatalf("fullJitter(1s) = %v, want in (0, 1s]", got)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package cloudfrontoriginpl
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestInstallPrefixesEmptyFirstLoadRequireNonEmpty(t *testing.T) {
+	s := &GenericSource{RequireNonEmpty: true}
+	if err := s.installPrefixes(nil); err == nil {
+		t.Fatal("expected an error for an empty result on first load with require_nonempty=true")
+	}
+	if got := s.snapshot(); len(got) != 0 {
+		t.Fatalf("snapshot = %v, want empty", got)
+	}
+}
+
+func TestInstallPrefixesEmptyFirstLoadAllowed(t *testing.T) {
+	s := &GenericSource{}
+	if err := s.installPrefixes(nil); err != nil {
+		t.Fatalf("installPrefixes(nil) = %v, want nil", err)
+	}
+	if got := s.snapshot(); len(got) != 0 {
+		t.Fatalf("snapshot = %v, want empty", got)
+	}
+}
+
+func TestInstallPrefixesEmptyResultKeepsPrevious(t *testing.T) {
+	prev := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	s := &GenericSource{RequireNonEmpty: true, current: prev}
+	if err := s.installPrefixes(nil); err != nil {
+		t.Fatalf("installPrefixes(nil) with a non-empty previous set = %v, want nil", err)
+	}
+	if got := s.snapshot(); len(got) != 1 || got[0] != prev[0] {
+		t.Fatalf("snapshot = %v, want previous set %v preserved", got, prev)
+	}
+}
+
+func TestInstallPrefixesNonEmptyResult(t *testing.T) {
+	s := &GenericSource{current: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	next := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if err := s.installPrefixes(next); err != nil {
+		t.Fatalf("installPrefixes(next) = %v, want nil", err)
+	}
+	got := s.snapshot()
+	if len(got) != len(next) {
+		t.Fatalf("snapshot = %v, want %v", got, next)
+	}
+}
@@ -0,0 +1,95 @@
+package cloudfrontoriginpl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+const testIPRangesDoc = `{
+	"syncToken": "1",
+	"createDate": "2024-01-01-00-00-00",
+	"prefixes": [
+		{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT_ORIGIN_FACING"},
+		{"ip_prefix": "10.0.0.0/8", "region": "us-east-1", "service": "EC2"}
+	],
+	"ipv6_prefixes": [
+		{"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT_ORIGIN_FACING"}
+	]
+}`
+
+func withFakePublicRangesServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	prev := publicRangesURL
+	publicRangesURL = srv.URL
+	t.Cleanup(func() { publicRangesURL = prev })
+}
+
+func TestRefreshFromPublicRangesFiltersByService(t *testing.T) {
+	withFakePublicRangesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIPRangesDoc))
+	})
+
+	s := &GenericSource{PublicRangesService: "CLOUDFRONT_ORIGIN_FACING"}
+	if err := s.refreshFromPublicRanges(context.Background()); err != nil {
+		t.Fatalf("refreshFromPublicRanges: %v", err)
+	}
+
+	got := s.snapshot()
+	want := []netip.Prefix{netip.MustParsePrefix("13.32.0.0/15")}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("snapshot = %v, want %v (EC2-service prefix should be filtered out)", got, want)
+	}
+}
+
+func TestRefreshFromPublicRangesIncludesIPv6WhenEnabled(t *testing.T) {
+	withFakePublicRangesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIPRangesDoc))
+	})
+
+	s := &GenericSource{PublicRangesService: "CLOUDFRONT_ORIGIN_FACING", IncludeIPv6: true}
+	if err := s.refreshFromPublicRanges(context.Background()); err != nil {
+		t.Fatalf("refreshFromPublicRanges: %v", err)
+	}
+
+	got := s.snapshot()
+	if len(got) != 2 {
+		t.Fatalf("snapshot = %v, want 2 prefixes (v4 + v6)", got)
+	}
+}
+
+func TestRefreshFromPublicRangesNotModifiedLeavesSetUnchanged(t *testing.T) {
+	const etag = `"etag-123"`
+	requests := 0
+	withFakePublicRangesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(testIPRangesDoc))
+	})
+
+	s := &GenericSource{PublicRangesService: "CLOUDFRONT_ORIGIN_FACING"}
+	if err := s.refreshFromPublicRanges(context.Background()); err != nil {
+		t.Fatalf("initial refreshFromPublicRanges: %v", err)
+	}
+	first := s.snapshot()
+
+	if err := s.refreshFromPublicRanges(context.Background()); err != nil {
+		t.Fatalf("second refreshFromPublicRanges: %v", err)
+	}
+	second := s.snapshot()
+
+	if requests != 2 {
+		t.Fatalf("got %d requests, want 2 (second should send If-None-Match)", requests)
+	}
+	if len(first) != len(second) || len(second) != 1 || first[0] != second[0] {
+		t.Fatalf("a 304 response should leave the current set unchanged: first=%v second=%v", first, second)
+	}
+}
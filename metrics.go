@@ -0,0 +1,112 @@
+package cloudfrontoriginpl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// sourceMetrics holds the Prometheus collectors registered for a Source.
+// They're created once per Provision and updated from refreshOnce.
+type sourceMetrics struct {
+	prefixesTotal   *prometheus.GaugeVec
+	refreshTotal    *prometheus.CounterVec
+	lastRefresh     prometheus.Gauge
+	refreshDuration prometheus.Histogram
+}
+
+const metricsNamespace = "cfoplsource"
+
+// registerMetrics registers this Source's collectors with Caddy's metrics
+// registry. Registering the same collector twice (e.g. across config
+// reloads, or a second Source instance sharing this process's registry) is
+// expected, so an AlreadyRegisteredError adopts are.ExistingCollector
+// instead of failing Provision or leaving m pointed at an orphaned
+// collector that Set/Inc calls would update but /metrics would never see.
+func registerMetrics(ctx caddy.Context) *sourceMetrics {
+	reg := ctx.GetMetricsRegistry()
+	if reg == nil {
+		return nil
+	}
+
+	m := &sourceMetrics{
+		prefixesTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "prefixes_total",
+			Help:      "Number of prefixes currently installed, by address family.",
+		}, []string{"family"}),
+		refreshTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "refresh_total",
+			Help:      "Number of refresh attempts, by result.",
+		}, []string{"result"}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_refresh_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful refresh.",
+		}),
+		refreshDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "refresh_duration_seconds",
+			Help:      "Duration of refresh attempts in seconds.",
+		}),
+	}
+
+	if err := reg.Register(m.prefixesTotal); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			caddy.Log().Warn("cloudfront_origin_pl: could not register metric", zap.Error(err))
+		} else if existing, ok := are.ExistingCollector.(*prometheus.GaugeVec); ok {
+			m.prefixesTotal = existing
+		}
+	}
+	if err := reg.Register(m.refreshTotal); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			caddy.Log().Warn("cloudfront_origin_pl: could not register metric", zap.Error(err))
+		} else if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+			m.refreshTotal = existing
+		}
+	}
+	if err := reg.Register(m.lastRefresh); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			caddy.Log().Warn("cloudfront_origin_pl: could not register metric", zap.Error(err))
+		} else if existing, ok := are.ExistingCollector.(prometheus.Gauge); ok {
+			m.lastRefresh = existing
+		}
+	}
+	if err := reg.Register(m.refreshDuration); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			caddy.Log().Warn("cloudfront_origin_pl: could not register metric", zap.Error(err))
+		} else if existing, ok := are.ExistingCollector.(prometheus.Histogram); ok {
+			m.refreshDuration = existing
+		}
+	}
+	return m
+}
+
+// observeRefresh records the outcome of a refresh attempt.
+func (m *sourceMetrics) observeRefresh(result string, dur time.Duration) {
+	if m == nil {
+		return
+	}
+	m.refreshTotal.WithLabelValues(result).Inc()
+	m.refreshDuration.Observe(dur.Seconds())
+	if result == "ok" {
+		m.lastRefresh.Set(float64(time.Now().Unix()))
+	}
+}
+
+// setPrefixCounts updates the current prefix gauges from an installed set.
+func (m *sourceMetrics) setPrefixCounts(v4, v6 int) {
+	if m == nil {
+		return
+	}
+	m.prefixesTotal.WithLabelValues("v4").Set(float64(v4))
+	m.prefixesTotal.WithLabelValues("v6").Set(float64(v6))
+}